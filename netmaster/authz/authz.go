@@ -0,0 +1,122 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package authz lets netmaster route config API calls through an ordered
+// chain of authorization plugins before mutating state.
+package authz
+
+import (
+	"golang.org/x/net/context"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Request describes the config API call a plugin is being asked to allow.
+type Request struct {
+	User        string
+	Action      string
+	Tenant      string
+	Network     string
+	RequestBody []byte
+}
+
+// Response is a plugin's verdict on a Request.
+type Response struct {
+	Allow bool
+	Msg   string
+}
+
+// Plugin is implemented by anything that can authorize a netmaster config
+// API call, whether built in or resolved over a remote plugin socket.
+type Plugin interface {
+	Name() string
+	AuthZRequest(ctx context.Context, req *Request) (*Response, error)
+	AuthZResponse(ctx context.Context, req *Request, resp *Response) (*Response, error)
+}
+
+// contextKey is an unexported type so netmaster/authz doesn't collide with
+// context values set by other packages.
+type contextKey int
+
+const userContextKey contextKey = 0
+
+// NewContext returns a context carrying the caller identity a Request is
+// built from.
+func NewContext(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+// UserFromContext returns the caller identity stashed by NewContext, or the
+// empty string if none was set.
+func UserFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userContextKey).(string)
+	return user
+}
+
+// Chain is an ordered list of plugins. AuthZRequest/AuthZResponse walk the
+// chain in order and short-circuit on the first deny.
+type Chain struct {
+	plugins []Plugin
+}
+
+// NewChain builds a Chain from an ordered plugin list.
+func NewChain(plugins ...Plugin) *Chain {
+	return &Chain{plugins: plugins}
+}
+
+// Names returns the ordered plugin names currently installed in the chain,
+// mainly for logging and status reporting.
+func (c *Chain) Names() []string {
+	names := make([]string, len(c.plugins))
+	for i, p := range c.plugins {
+		names[i] = p.Name()
+	}
+	return names
+}
+
+// AuthZRequest runs req through every plugin in order and returns the first
+// denial, or an allow once every plugin has approved.
+func (c *Chain) AuthZRequest(ctx context.Context, req *Request) (*Response, error) {
+	for _, p := range c.plugins {
+		resp, err := p.AuthZRequest(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if !resp.Allow {
+			log.Infof("authz: plugin %s denied %s on tenant %s: %s", p.Name(), req.Action, req.Tenant, resp.Msg)
+			return resp, nil
+		}
+	}
+
+	return &Response{Allow: true}, nil
+}
+
+// AuthZResponse lets each plugin inspect/veto the result of a call it
+// already allowed.
+func (c *Chain) AuthZResponse(ctx context.Context, req *Request, resp *Response) (*Response, error) {
+	for _, p := range c.plugins {
+		var err error
+		resp, err = p.AuthZResponse(ctx, req, resp)
+		if err != nil {
+			return nil, err
+		}
+		if !resp.Allow {
+			log.Infof("authz: plugin %s denied response for %s on tenant %s: %s", p.Name(), req.Action, req.Tenant, resp.Msg)
+			return resp, nil
+		}
+	}
+
+	return resp, nil
+}
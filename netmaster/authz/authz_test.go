@@ -0,0 +1,98 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+type fakePlugin struct {
+	name  string
+	allow bool
+}
+
+func (p *fakePlugin) Name() string { return p.name }
+
+func (p *fakePlugin) AuthZRequest(ctx context.Context, req *Request) (*Response, error) {
+	if p.allow {
+		return &Response{Allow: true}, nil
+	}
+	return &Response{Allow: false, Msg: p.name + " denied"}, nil
+}
+
+func (p *fakePlugin) AuthZResponse(ctx context.Context, req *Request, resp *Response) (*Response, error) {
+	return resp, nil
+}
+
+func TestChainAuthZRequestAllowsWhenEmpty(t *testing.T) {
+	chain := NewChain()
+
+	resp, err := chain.AuthZRequest(context.Background(), &Request{Action: "CreateTenant", Tenant: "t1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !resp.Allow {
+		t.Error("an empty chain should allow every request")
+	}
+}
+
+func TestChainAuthZRequestShortCircuitsOnFirstDeny(t *testing.T) {
+	never := &fakePlugin{name: "never-runs", allow: true}
+	chain := NewChain(&fakePlugin{name: "denier", allow: false}, never)
+
+	resp, err := chain.AuthZRequest(context.Background(), &Request{Action: "DeleteTenant", Tenant: "t1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Allow {
+		t.Error("expected the chain to deny once the first plugin denies")
+	}
+	if resp.Msg != "denier denied" {
+		t.Errorf("expected the denying plugin's message, got %q", resp.Msg)
+	}
+}
+
+func TestTenantScopePlugin(t *testing.T) {
+	plugin := NewTenantScopePlugin(map[string][]string{
+		"alice": {"t1", "t2"},
+	})
+
+	allow, err := plugin.AuthZRequest(context.Background(), &Request{User: "alice", Tenant: "t1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !allow.Allow {
+		t.Error("expected alice to be allowed on t1")
+	}
+
+	deny, err := plugin.AuthZRequest(context.Background(), &Request{User: "alice", Tenant: "t3"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deny.Allow {
+		t.Error("expected alice to be denied on t3, which she isn't scoped to")
+	}
+
+	unknown, err := plugin.AuthZRequest(context.Background(), &Request{User: "bob", Tenant: "t1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if unknown.Allow {
+		t.Error("expected an unscoped user to be denied")
+	}
+}
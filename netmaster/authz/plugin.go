@@ -0,0 +1,79 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/docker/go-plugins-helpers/sdk"
+
+	"github.com/contiv/netplugin/core"
+)
+
+const authzServiceMethod = "AuthZPlugin"
+
+// remotePlugin resolves a named plugin over the Docker plugin socket and
+// proxies AuthZRequest/AuthZResponse calls to it.
+type remotePlugin struct {
+	name   string
+	client *sdk.Client
+}
+
+// resolveRemotePlugin looks up name in the plugin registry (/run/docker/plugins
+// or ~/.docker/plugins) and returns a Plugin backed by it.
+func resolveRemotePlugin(name string) (Plugin, error) {
+	client, err := sdk.NewClient(name, nil)
+	if err != nil {
+		return nil, core.Errorf("unable to resolve authz plugin %s: %v", name, err)
+	}
+
+	return &remotePlugin{name: name, client: client}, nil
+}
+
+func (p *remotePlugin) Name() string {
+	return p.name
+}
+
+func (p *remotePlugin) AuthZRequest(ctx context.Context, req *Request) (*Response, error) {
+	var resp Response
+	if err := p.client.Call(authzServiceMethod+".AuthZReq", req, &resp); err != nil {
+		return nil, core.Errorf("authz plugin %s request failed: %v", p.name, err)
+	}
+	return &resp, nil
+}
+
+func (p *remotePlugin) AuthZResponse(ctx context.Context, req *Request, in *Response) (*Response, error) {
+	var resp Response
+	if err := p.client.Call(authzServiceMethod+".AuthZRes", in, &resp); err != nil {
+		return nil, core.Errorf("authz plugin %s response check failed: %v", p.name, err)
+	}
+	return &resp, nil
+}
+
+// LoadPlugins resolves the given plugin names, in order, into a
+// ready-to-use Chain.
+func LoadPlugins(names []string) (*Chain, error) {
+	plugins := make([]Plugin, 0, len(names))
+	for _, name := range names {
+		p, err := resolveRemotePlugin(name)
+		if err != nil {
+			return nil, err
+		}
+		plugins = append(plugins, p)
+	}
+
+	return NewChain(plugins...), nil
+}
@@ -0,0 +1,71 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+// tenantScopePlugin is the built-in "tenant-scope" plugin: it restricts a
+// caller identity to a fixed set of tenant names and denies everything
+// else, including global (tenant-less) calls.
+type tenantScopePlugin struct {
+	// allowed maps a caller identity to the tenant names it may touch.
+	allowed map[string]map[string]bool
+}
+
+// NewTenantScopePlugin builds the built-in tenant-scope plugin from a map
+// of caller identity to the tenants it's allowed to operate on.
+func NewTenantScopePlugin(allowed map[string][]string) Plugin {
+	scoped := make(map[string]map[string]bool, len(allowed))
+	for user, tenants := range allowed {
+		set := make(map[string]bool, len(tenants))
+		for _, t := range tenants {
+			set[t] = true
+		}
+		scoped[user] = set
+	}
+
+	return &tenantScopePlugin{allowed: scoped}
+}
+
+func (p *tenantScopePlugin) Name() string {
+	return "tenant-scope"
+}
+
+func (p *tenantScopePlugin) AuthZRequest(ctx context.Context, req *Request) (*Response, error) {
+	tenants, ok := p.allowed[req.User]
+	if !ok {
+		return &Response{Allow: false, Msg: fmt.Sprintf("no tenant scope configured for user %s", req.User)}, nil
+	}
+
+	if req.Tenant == "" {
+		return &Response{Allow: false, Msg: fmt.Sprintf("user %s is not permitted to perform global actions", req.User)}, nil
+	}
+
+	if !tenants[req.Tenant] {
+		return &Response{Allow: false, Msg: fmt.Sprintf("user %s is not scoped to tenant %s", req.User, req.Tenant)}, nil
+	}
+
+	return &Response{Allow: true}, nil
+}
+
+func (p *tenantScopePlugin) AuthZResponse(ctx context.Context, req *Request, resp *Response) (*Response, error) {
+	// tenant-scope only vets the request, not the response
+	return resp, nil
+}
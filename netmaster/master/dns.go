@@ -0,0 +1,460 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"net"
+	"sync"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/miekg/dns"
+)
+
+const dnsServerPort = 53
+
+// loopbackAllocMu/nextLoopbackHost hand out a loopback alias per tenant
+// zone. Every zone's listener runs in netmaster's own network namespace on
+// its alias at port 53; zones are not bound inside container network
+// namespaces. Aliases run from 127.0.0.2 to 127.0.0.255 and then wrap
+// around.
+var (
+	loopbackAllocMu  sync.Mutex
+	nextLoopbackHost = 2 // .1 is reserved for the host's own loopback use
+)
+
+func allocateTenantListenAddr() net.IP {
+	loopbackAllocMu.Lock()
+	defer loopbackAllocMu.Unlock()
+
+	ip := net.IPv4(127, 0, 0, byte(nextLoopbackHost))
+	nextLoopbackHost++
+	if nextLoopbackHost > 255 {
+		nextLoopbackHost = 2
+	}
+	return ip
+}
+
+// dnsRecord holds the address data served for a single name.
+type dnsRecord struct {
+	ip4 []net.IP
+	ip6 []net.IP
+}
+
+// dnsZone is the set of records owned by one tenant. A/AAAA records are
+// keyed by the endpoint name CreateEndpoint computes via getEpName, scoped
+// as "<name>.<tenant>.", and SRV records by "<service>.<tenant>.".
+type dnsZone struct {
+	mu      sync.RWMutex
+	tenant  string
+	records map[string]*dnsRecord
+	srv     map[string][]*dns.SRV
+	// ptr maps a reverse "x.y.z.w.in-addr.arpa."/"...ip6.arpa." name back
+	// to the forward name that owns the address, so PTR queries don't have
+	// to be looked up in the forward-name records map.
+	ptr map[string]string
+}
+
+func newDNSZone(tenant string) *dnsZone {
+	return &dnsZone{
+		tenant:  tenant,
+		records: make(map[string]*dnsRecord),
+		srv:     make(map[string][]*dns.SRV),
+		ptr:     make(map[string]string),
+	}
+}
+
+func (z *dnsZone) addRecord(name string, ip4, ip6 net.IP) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	rec, ok := z.records[name]
+	if !ok {
+		rec = &dnsRecord{}
+		z.records[name] = rec
+	}
+	if ip4 != nil {
+		rec.ip4 = append(rec.ip4, ip4)
+		if arpa, err := dns.ReverseAddr(ip4.String()); err == nil {
+			z.ptr[arpa] = name
+		}
+	}
+	if ip6 != nil {
+		rec.ip6 = append(rec.ip6, ip6)
+		if arpa, err := dns.ReverseAddr(ip6.String()); err == nil {
+			z.ptr[arpa] = name
+		}
+	}
+}
+
+func (z *dnsZone) delRecord(name string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	delete(z.records, name)
+	delete(z.srv, name)
+
+	for arpa, owner := range z.ptr {
+		if owner == name {
+			delete(z.ptr, arpa)
+		}
+	}
+}
+
+// addSRV adds one SRV record under name, pointing at target:port. A name
+// can carry more than one SRV record, e.g. one per running task behind a
+// service.
+func (z *dnsZone) addSRV(name, target string, port uint16) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	z.srv[name] = append(z.srv[name], &dns.SRV{
+		Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 0},
+		Target: target,
+		Port:   port,
+	})
+}
+
+// delSRV removes the SRV record under name that points at target, leaving
+// any other targets registered under the same name untouched.
+func (z *dnsZone) delSRV(name, target string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	entries := z.srv[name][:0]
+	for _, srv := range z.srv[name] {
+		if srv.Target != target {
+			entries = append(entries, srv)
+		}
+	}
+	if len(entries) == 0 {
+		delete(z.srv, name)
+	} else {
+		z.srv[name] = entries
+	}
+}
+
+// clearSRV removes every SRV record under name, e.g. before a reconcile
+// pass rebuilds it from the current set of live targets.
+func (z *dnsZone) clearSRV(name string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	delete(z.srv, name)
+}
+
+func (z *dnsZone) lookup(name string) *dnsRecord {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	return z.records[name]
+}
+
+func (z *dnsZone) lookupPTR(arpaName string) (string, bool) {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	name, ok := z.ptr[arpaName]
+	return name, ok
+}
+
+// healthCheckLabel names a synthetic A record registerTenantZone seeds into
+// every zone so the DNS supervisor's health probe always has something the
+// zone can answer locally, without depending on real endpoint state or
+// falling through to the upstream forwarder.
+const healthCheckLabel = "healthcheck"
+
+func healthCheckName(tenantName string) string {
+	return dns.Fqdn(healthCheckLabel + "." + tenantName)
+}
+
+// dnsResolver is netmaster's embedded, in-process replacement for the
+// per-tenant skydns container. It answers A/AAAA/PTR/SRV queries directly
+// from netmaster's in-memory endpoint state and forwards everything else
+// upstream.
+type dnsResolver struct {
+	mu       sync.RWMutex
+	zones    map[string]*dnsZone
+	conns    map[string]*net.UDPConn
+	addrs    map[string]net.IP
+	upstream []string
+	updateCh chan mastercfg.EndpointUpdate
+	stopCh   chan struct{}
+}
+
+var resolver = &dnsResolver{
+	zones:    make(map[string]*dnsZone),
+	conns:    make(map[string]*net.UDPConn),
+	addrs:    make(map[string]net.IP),
+	upstream: defaultUpstreamServers(),
+	updateCh: make(chan mastercfg.EndpointUpdate, 256),
+	stopCh:   make(chan struct{}),
+}
+
+func init() {
+	go resolver.watchEndpointUpdates()
+}
+
+// defaultUpstreamServers reads the host's resolv.conf for the nameservers
+// unresolved queries should be forwarded to.
+func defaultUpstreamServers() []string {
+	cfg, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil || cfg == nil || len(cfg.Servers) == 0 {
+		log.Warnf("Unable to read upstream nameservers, defaulting to 8.8.8.8. Error: %v", err)
+		return []string{"8.8.8.8:53"}
+	}
+
+	servers := make([]string, 0, len(cfg.Servers))
+	for _, s := range cfg.Servers {
+		servers = append(servers, net.JoinHostPort(s, cfg.Port))
+	}
+	return servers
+}
+
+// registerTenantZone creates the DNS zone for a tenant and starts a UDP
+// listener for it, bound to a loopback address unique to the tenant so
+// concurrent tenants never collide on the same socket. It replaces
+// startServiceContainer.
+func (r *dnsResolver) registerTenantZone(tenantName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.zones[tenantName]; ok {
+		return core.Errorf("dns zone for tenant %s already registered", tenantName)
+	}
+
+	addr := allocateTenantListenAddr()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: addr, Port: dnsServerPort})
+	if err != nil {
+		log.Errorf("Unable to bind embedded DNS resolver for tenant %s on %s. Error: %v", tenantName, addr, err)
+		return err
+	}
+
+	zone := newDNSZone(tenantName)
+	zone.addRecord(healthCheckName(tenantName), addr, nil)
+	r.zones[tenantName] = zone
+	r.conns[tenantName] = conn
+	r.addrs[tenantName] = addr
+
+	go r.serve(tenantName, conn, zone)
+
+	return nil
+}
+
+// unregisterTenantZone tears down a tenant's zone and its listener. It
+// replaces stopAndRemoveServiceContainer.
+func (r *dnsResolver) unregisterTenantZone(tenantName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conn, ok := r.conns[tenantName]
+	if !ok {
+		return nil
+	}
+
+	// Drop the cached UDP connection from the resolver's maps before closing
+	// it, so no later lookup through the resolver hands out a closed socket.
+	// serve() keeps its own reference and stops on its next read error.
+	delete(r.conns, tenantName)
+	delete(r.zones, tenantName)
+	delete(r.addrs, tenantName)
+
+	if err := conn.Close(); err != nil {
+		log.Errorf("Error closing embedded DNS listener for tenant %s. Error: %v", tenantName, err)
+		return err
+	}
+
+	return nil
+}
+
+// tenantListenAddr returns the loopback address a tenant's zone is bound
+// to, and false if the tenant has no zone registered.
+func (r *dnsResolver) tenantListenAddr(tenantName string) (net.IP, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	addr, ok := r.addrs[tenantName]
+	return addr, ok
+}
+
+// serve answers queries for a single tenant zone until conn is closed.
+func (r *dnsResolver) serve(tenantName string, conn *net.UDPConn, zone *dnsZone) {
+	buf := make([]byte, dns.DefaultMsgSize)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// conn was closed by unregisterTenantZone; stop serving.
+			return
+		}
+
+		req := new(dns.Msg)
+		if err := req.Unpack(buf[:n]); err != nil {
+			log.Debugf("Dropping malformed DNS query for tenant %s. Error: %v", tenantName, err)
+			continue
+		}
+
+		resp := r.answer(zone, req)
+		out, err := resp.Pack()
+		if err != nil {
+			log.Errorf("Error packing DNS response for tenant %s. Error: %v", tenantName, err)
+			continue
+		}
+
+		if _, err := conn.WriteToUDP(out, addr); err != nil {
+			log.Errorf("Error writing DNS response for tenant %s. Error: %v", tenantName, err)
+		}
+	}
+}
+
+// answer resolves a query from the tenant zone, falling back to the
+// upstream nameservers for anything the zone doesn't own.
+func (r *dnsResolver) answer(zone *dnsZone, req *dns.Msg) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+
+	if zone == nil || len(req.Question) == 0 {
+		return resp
+	}
+
+	q := req.Question[0]
+	name := dns.Fqdn(q.Name)
+
+	// PTR queries arrive keyed by the reverse in-addr.arpa./ip6.arpa. name,
+	// never a key in zone.records, so they need their own lookup path.
+	if q.Qtype == dns.TypePTR {
+		target, ok := zone.lookupPTR(name)
+		if !ok {
+			return r.forward(req)
+		}
+		resp.Answer = append(resp.Answer, &dns.PTR{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 0},
+			Ptr: target,
+		})
+		return resp
+	}
+
+	// SRV records live under service names, which have no entry in
+	// zone.records, so they are looked up on their own as well.
+	if q.Qtype == dns.TypeSRV {
+		zone.mu.RLock()
+		for _, srv := range zone.srv[name] {
+			resp.Answer = append(resp.Answer, srv)
+		}
+		zone.mu.RUnlock()
+		if len(resp.Answer) == 0 {
+			return r.forward(req)
+		}
+		return resp
+	}
+
+	rec := zone.lookup(name)
+	if rec == nil {
+		return r.forward(req)
+	}
+
+	switch q.Qtype {
+	case dns.TypeA:
+		for _, ip := range rec.ip4 {
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 0},
+				A:   ip,
+			})
+		}
+	case dns.TypeAAAA:
+		for _, ip := range rec.ip6 {
+			resp.Answer = append(resp.Answer, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 0},
+				AAAA: ip,
+			})
+		}
+	}
+
+	return resp
+}
+
+// forward relays a query the local zone can't answer to the upstream
+// nameservers configured on the host.
+func (r *dnsResolver) forward(req *dns.Msg) *dns.Msg {
+	c := new(dns.Client)
+	for _, server := range r.upstream {
+		resp, _, err := c.Exchange(req, server)
+		if err == nil && resp != nil {
+			return resp
+		}
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Rcode = dns.RcodeServerFailure
+	return resp
+}
+
+// publish queues an endpoint create/delete for watchEndpointUpdates to apply
+// against the tenant's zone. CreateEndpoint/DeleteEndpoint are the only
+// callers; it's the producer side of updateCh.
+func (r *dnsResolver) publish(ev mastercfg.EndpointUpdate) {
+	select {
+	case r.updateCh <- ev:
+	default:
+		log.Errorf("Dropping dns update for tenant %s, name %s: update channel full", ev.Tenant, ev.Name)
+	}
+}
+
+// watchEndpointUpdates applies every endpoint create/delete CreateEndpoint
+// and DeleteEndpoint publish, so the resolver's zones stay in sync with
+// live endpoint state without those callers blocking on zone locks.
+func (r *dnsResolver) watchEndpointUpdates() {
+	for {
+		select {
+		case ev, ok := <-r.updateCh:
+			if !ok {
+				return
+			}
+			r.applyUpdate(ev)
+		case <-r.stopCh:
+			return
+		}
+	}
+}
+
+func (r *dnsResolver) applyUpdate(ev mastercfg.EndpointUpdate) {
+	r.mu.RLock()
+	zone, ok := r.zones[ev.Tenant]
+	r.mu.RUnlock()
+	if !ok || zone == nil {
+		return
+	}
+
+	svcName := ""
+	if ev.ServiceName != "" {
+		svcName = dns.Fqdn(ev.ServiceName + "." + ev.Tenant)
+	}
+
+	if ev.Deleted {
+		zone.delRecord(ev.Name)
+		if svcName != "" {
+			zone.delSRV(svcName, ev.Name)
+		}
+		return
+	}
+
+	zone.addRecord(ev.Name, ev.IPv4Addr, ev.IPv6Addr)
+	if svcName != "" {
+		zone.addSRV(svcName, ev.Name, ev.Port)
+	}
+}
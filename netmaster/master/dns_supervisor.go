@@ -0,0 +1,272 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/contiv/netplugin/core"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultMaxDNSRestarts  = 5
+	dnsHealthCheckInterval = 10 * time.Second
+	dnsHealthCheckTimeout  = 2 * time.Second
+)
+
+// dnsStatus is the supervisor's view of a tenant's embedded DNS zone.
+type dnsStatus int
+
+const (
+	dnsStatusHealthy dnsStatus = iota
+	dnsStatusDegraded
+	dnsStatusFailed
+)
+
+func (s dnsStatus) String() string {
+	switch s {
+	case dnsStatusHealthy:
+		return "healthy"
+	case dnsStatusDegraded:
+		return "degraded"
+	case dnsStatusFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// TenantDNSStatus is the health snapshot GetTenantStatus returns for a
+// tenant's DNS zone.
+type TenantDNSStatus struct {
+	Tenant    string
+	Status    string
+	Restarts  int
+	LastError error
+}
+
+// dnsSupervisor polls one tenant's embedded DNS zone on an exponential
+// backoff and restarts it, up to maxRestarts.
+type dnsSupervisor struct {
+	mu          sync.RWMutex
+	tenantName  string
+	maxRestarts int
+	restarts    int
+	status      dnsStatus
+	lastErr     error
+	stopCh      chan struct{}
+}
+
+var (
+	supervisorsMu sync.Mutex
+	supervisors   = map[string]*dnsSupervisor{}
+)
+
+// startDNSSupervisor starts health supervision for a tenant's DNS zone. It
+// is a no-op if a supervisor for the tenant is already running.
+func startDNSSupervisor(tenantName string) {
+	supervisorsMu.Lock()
+	defer supervisorsMu.Unlock()
+
+	if _, ok := supervisors[tenantName]; ok {
+		return
+	}
+
+	sup := &dnsSupervisor{
+		tenantName:  tenantName,
+		maxRestarts: defaultMaxDNSRestarts,
+		status:      dnsStatusHealthy,
+		stopCh:      make(chan struct{}),
+	}
+	supervisors[tenantName] = sup
+
+	go sup.run()
+}
+
+// stopDNSSupervisor stops health supervision for a tenant, e.g. when the
+// tenant is deleted or its DNS zone is disabled.
+func stopDNSSupervisor(tenantName string) {
+	supervisorsMu.Lock()
+	defer supervisorsMu.Unlock()
+
+	sup, ok := supervisors[tenantName]
+	if !ok {
+		return
+	}
+
+	close(sup.stopCh)
+	delete(supervisors, tenantName)
+}
+
+// GetTenantStatus returns the last known DNS health for a tenant, and false
+// if no supervisor was started for it (e.g. DNS was never enabled) or its
+// supervisor was stopped. A supervisor that gave up after a permanent
+// failure still reports, with status "failed".
+func GetTenantStatus(tenantName string) (TenantDNSStatus, bool) {
+	supervisorsMu.Lock()
+	sup, ok := supervisors[tenantName]
+	supervisorsMu.Unlock()
+
+	if !ok {
+		return TenantDNSStatus{}, false
+	}
+
+	return sup.snapshot(), true
+}
+
+func (s *dnsSupervisor) snapshot() TenantDNSStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return TenantDNSStatus{
+		Tenant:    s.tenantName,
+		Status:    s.status.String(),
+		Restarts:  s.restarts,
+		LastError: s.lastErr,
+	}
+}
+
+func (s *dnsSupervisor) run() {
+	b := backoff.NewExponentialBackOff()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		default:
+		}
+
+		if err := s.healthCheck(); err != nil {
+			s.setDegraded(err)
+
+			wait := b.NextBackOff()
+			if wait == backoff.Stop {
+				s.permanentFailure()
+				return
+			}
+
+			log.Errorf("DNS health check failed for tenant %s, retrying in %s. Error: %v", s.tenantName, wait, err)
+
+			select {
+			case <-time.After(wait):
+			case <-s.stopCh:
+				return
+			}
+
+			if s.incrementRestarts() > s.maxRestarts {
+				s.permanentFailure()
+				return
+			}
+
+			if err := s.restartZone(); err != nil {
+				log.Errorf("Unable to restart DNS zone for tenant %s. Error: %v", s.tenantName, err)
+			}
+
+			continue
+		}
+
+		b.Reset()
+		s.setHealthy()
+
+		select {
+		case <-time.After(dnsHealthCheckInterval):
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// healthCheck sends an actual DNS query to the tenant's local listener and
+// requires an answer, rather than just checking that resolver.zones still
+// has an entry for the tenant: serve()'s loop exits silently the moment
+// ReadFromUDP errors out, leaving the zone entry in place with nothing left
+// to answer it, so map presence alone can never observe that crash.
+//
+// The query is for healthCheckName(s.tenantName), a synthetic A record
+// registerTenantZone seeds into every zone. Querying it rather than a real
+// tenant name keeps the probe answered locally: a real name that isn't
+// populated yet falls through to the upstream forwarder, which would make
+// health checks depend on outside network reachability instead of the
+// embedded listener.
+func (s *dnsSupervisor) healthCheck() error {
+	addr, ok := resolver.tenantListenAddr(s.tenantName)
+	if !ok {
+		return core.Errorf("no dns zone registered for tenant %s", s.tenantName)
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(healthCheckName(s.tenantName), dns.TypeA)
+
+	c := &dns.Client{Timeout: dnsHealthCheckTimeout}
+	server := net.JoinHostPort(addr.String(), strconv.Itoa(dnsServerPort))
+	resp, _, err := c.Exchange(m, server)
+	if err != nil {
+		return core.Errorf("dns health probe to %s failed for tenant %s: %v", server, s.tenantName, err)
+	}
+	if len(resp.Answer) == 0 {
+		return core.Errorf("dns health probe to %s for tenant %s returned no answer", server, s.tenantName)
+	}
+
+	return nil
+}
+
+func (s *dnsSupervisor) restartZone() error {
+	resolver.unregisterTenantZone(s.tenantName)
+	return resolver.registerTenantZone(s.tenantName)
+}
+
+// permanentFailure disables DNS for this tenant only, leaving every other
+// tenant's zone and the global dnsEnabled flag untouched.
+func (s *dnsSupervisor) permanentFailure() {
+	s.mu.Lock()
+	s.status = dnsStatusFailed
+	restarts := s.restarts
+	s.mu.Unlock()
+
+	log.Errorf("DNS subsystem for tenant %s failed permanently after %d restarts, disabling DNS for this tenant", s.tenantName, restarts)
+
+	if err := resolver.unregisterTenantZone(s.tenantName); err != nil {
+		log.Errorf("Error disabling dns zone for tenant %s. Error: %v", s.tenantName, err)
+	}
+}
+
+func (s *dnsSupervisor) setDegraded(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = dnsStatusDegraded
+	s.lastErr = err
+}
+
+func (s *dnsSupervisor) setHealthy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = dnsStatusHealthy
+	s.lastErr = nil
+}
+
+func (s *dnsSupervisor) incrementRestarts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.restarts++
+	return s.restarts
+}
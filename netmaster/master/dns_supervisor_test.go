@@ -0,0 +1,41 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import "testing"
+
+func TestDNSSupervisorHealthCheckProbesLiveSocket(t *testing.T) {
+	const tenant = "healthchecktenant"
+
+	if err := resolver.registerTenantZone(tenant); err != nil {
+		t.Fatalf("unable to register test tenant zone: %v", err)
+	}
+	defer resolver.unregisterTenantZone(tenant)
+
+	sup := &dnsSupervisor{tenantName: tenant, maxRestarts: defaultMaxDNSRestarts, stopCh: make(chan struct{})}
+
+	if err := sup.healthCheck(); err != nil {
+		t.Errorf("expected a healthy zone to pass its health check, got: %v", err)
+	}
+
+	if err := resolver.unregisterTenantZone(tenant); err != nil {
+		t.Fatalf("unable to unregister test tenant zone: %v", err)
+	}
+
+	if err := sup.healthCheck(); err == nil {
+		t.Error("expected the health check to fail once the tenant's zone is gone")
+	}
+}
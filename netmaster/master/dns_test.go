@@ -0,0 +1,136 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"net"
+	"testing"
+
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+
+	"github.com/miekg/dns"
+)
+
+func TestDNSZoneAddDeleteLookup(t *testing.T) {
+	zone := newDNSZone("tenant1")
+
+	ip4 := net.ParseIP("10.1.1.5")
+	ip6 := net.ParseIP("fe80::5")
+	zone.addRecord("web.tenant1.", ip4, ip6)
+
+	rec := zone.lookup("web.tenant1.")
+	if rec == nil {
+		t.Fatal("expected a record for web.tenant1., got nil")
+	}
+	if len(rec.ip4) != 1 || !rec.ip4[0].Equal(ip4) {
+		t.Errorf("expected ip4 %v, got %v", ip4, rec.ip4)
+	}
+	if len(rec.ip6) != 1 || !rec.ip6[0].Equal(ip6) {
+		t.Errorf("expected ip6 %v, got %v", ip6, rec.ip6)
+	}
+
+	arpa, err := dns.ReverseAddr(ip4.String())
+	if err != nil {
+		t.Fatalf("unexpected error building arpa name: %v", err)
+	}
+	target, ok := zone.lookupPTR(arpa)
+	if !ok || target != "web.tenant1." {
+		t.Errorf("expected PTR %s to resolve to web.tenant1., got %q (ok=%v)", arpa, target, ok)
+	}
+
+	zone.delRecord("web.tenant1.")
+
+	if rec := zone.lookup("web.tenant1."); rec != nil {
+		t.Errorf("expected record to be gone after delRecord, got %v", rec)
+	}
+	if _, ok := zone.lookupPTR(arpa); ok {
+		t.Errorf("expected PTR entry to be gone after delRecord")
+	}
+}
+
+func TestDNSZoneSRV(t *testing.T) {
+	zone := newDNSZone("tenant1")
+
+	zone.addSRV("web.tenant1.", "web-c1.tenant1.", 8080)
+	zone.addSRV("web.tenant1.", "web-c2.tenant1.", 8080)
+
+	srvs := zone.srv["web.tenant1."]
+	if len(srvs) != 2 {
+		t.Fatalf("expected 2 SRV records, got %d", len(srvs))
+	}
+
+	zone.delSRV("web.tenant1.", "web-c1.tenant1.")
+	srvs = zone.srv["web.tenant1."]
+	if len(srvs) != 1 || srvs[0].Target != "web-c2.tenant1." {
+		t.Fatalf("expected only web-c2.tenant1. to remain, got %v", srvs)
+	}
+
+	zone.clearSRV("web.tenant1.")
+	if _, ok := zone.srv["web.tenant1."]; ok {
+		t.Errorf("expected clearSRV to remove the name entirely")
+	}
+}
+
+func TestResolverApplyUpdatePublishesServiceSRV(t *testing.T) {
+	const tenant = "srvtenant"
+
+	if err := resolver.registerTenantZone(tenant); err != nil {
+		t.Fatalf("unable to register test tenant zone: %v", err)
+	}
+	defer resolver.unregisterTenantZone(tenant)
+
+	resolver.mu.RLock()
+	zone := resolver.zones[tenant]
+	resolver.mu.RUnlock()
+
+	ev := mastercfg.EndpointUpdate{
+		Tenant:      tenant,
+		Name:        "web-c1.srvtenant.",
+		ServiceName: "web",
+		Port:        8080,
+		IPv4Addr:    net.ParseIP("10.1.1.10"),
+	}
+	resolver.applyUpdate(ev)
+
+	if rec := zone.lookup(ev.Name); rec == nil || len(rec.ip4) != 1 {
+		t.Fatalf("expected an A record for %s, got %v", ev.Name, rec)
+	}
+
+	srvName := dns.Fqdn("web." + tenant)
+	srvs := zone.srv[srvName]
+	if len(srvs) != 1 || srvs[0].Target != ev.Name || srvs[0].Port != 8080 {
+		t.Fatalf("expected one SRV record targeting %s:8080, got %v", ev.Name, srvs)
+	}
+
+	ev.Deleted = true
+	resolver.applyUpdate(ev)
+
+	if rec := zone.lookup(ev.Name); rec != nil {
+		t.Errorf("expected the A record to be gone after a deleted update, got %v", rec)
+	}
+	if srvs := zone.srv[srvName]; len(srvs) != 0 {
+		t.Errorf("expected the SRV record to be gone after a deleted update, got %v", srvs)
+	}
+}
+
+func TestAllocateTenantListenAddrUnique(t *testing.T) {
+	first := allocateTenantListenAddr()
+	second := allocateTenantListenAddr()
+
+	if first.Equal(second) {
+		t.Errorf("expected distinct loopback addresses per tenant, got %v twice", first)
+	}
+}
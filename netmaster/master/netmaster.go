@@ -18,9 +18,12 @@ package master
 import (
 	"errors"
 	"fmt"
+	"net"
+
+	"golang.org/x/net/context"
 
-	"github.com/cenkalti/backoff"
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/authz"
 	"github.com/contiv/netplugin/netmaster/gstate"
 	"github.com/contiv/netplugin/netmaster/intent"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
@@ -28,12 +31,11 @@ import (
 	"github.com/contiv/netplugin/utils/netutils"
 
 	log "github.com/Sirupsen/logrus"
-	"github.com/samalba/dockerclient"
+	"github.com/miekg/dns"
 )
 
 const (
 	defaultInfraNetName = "infra"
-	defaultSkyDNSImage  = "skynetservices/skydns:latest"
 )
 
 // Run Time config of netmaster
@@ -44,15 +46,57 @@ type nmRunTimeConf struct {
 
 var masterRTCfg nmRunTimeConf
 
+// authzChain is the ordered set of authorization plugins the config APIs
+// below route through before mutating state. An empty chain allows every
+// request. The chain starts empty and changes only through SetAuthzChain.
+var authzChain = authz.NewChain()
+
+// SetAuthzChain installs a new authorization chain, swapping it in for the
+// one currently in use without a restart. Reload does not call it, so a
+// SIGHUP leaves the installed chain unchanged.
+func SetAuthzChain(chain *authz.Chain) {
+	authzChain = chain
+}
+
+// checkAuthz runs action against the installed authz chain before a config
+// API is allowed to mutate state.
+func checkAuthz(ctx context.Context, action, tenantName string) error {
+	resp, err := authzChain.AuthZRequest(ctx, &authz.Request{
+		User:   authz.UserFromContext(ctx),
+		Action: action,
+		Tenant: tenantName,
+	})
+	if err != nil {
+		return err
+	}
+
+	if !resp.Allow {
+		return core.Errorf("%s denied: %s", action, resp.Msg)
+	}
+
+	return nil
+}
+
 // SetClusterMode sets the cluster mode for the contiv plugin
 func SetClusterMode(cm string) error {
 	switch cm {
 	case "docker":
 	case "kubernetes":
+	case "swarm":
 	case "test": // internal mode used for integration testing
 		break
 	default:
-		return core.Errorf("%s not a valid cluster mode {docker | kubernetes}", cm)
+		return core.Errorf("%s not a valid cluster mode {docker | kubernetes | swarm}", cm)
+	}
+
+	if masterRTCfg.clusterMode == "swarm" && cm != "swarm" {
+		stopSwarmReconciler()
+	}
+
+	if cm == "swarm" && masterRTCfg.clusterMode != "swarm" {
+		if err := startSwarmReconciler(); err != nil {
+			return err
+		}
 	}
 
 	masterRTCfg.clusterMode = cm
@@ -111,8 +155,12 @@ func validateTenantConfig(tenant *intent.ConfigTenant) error {
 }
 
 // CreateGlobal sets the global state
-func CreateGlobal(stateDriver core.StateDriver, gc *intent.ConfigGlobal) error {
+func CreateGlobal(ctx context.Context, stateDriver core.StateDriver, gc *intent.ConfigGlobal) error {
 	log.Infof("Received global create with intent {%v}", gc)
+	if err := checkAuthz(ctx, "CreateGlobal", ""); err != nil {
+		return err
+	}
+
 	var err error
 	gcfgUpdateList := []string{}
 
@@ -197,7 +245,20 @@ func CreateGlobal(stateDriver core.StateDriver, gc *intent.ConfigGlobal) error {
 }
 
 // UpdateGlobal updates the global state
-func UpdateGlobal(stateDriver core.StateDriver, gc *intent.ConfigGlobal) error {
+func UpdateGlobal(ctx context.Context, stateDriver core.StateDriver, gc *intent.ConfigGlobal) error {
+	if err := checkAuthz(ctx, "UpdateGlobal", ""); err != nil {
+		return err
+	}
+
+	return updateGlobal(stateDriver, gc)
+}
+
+// updateGlobal applies a global config update without going through authz.
+// It backs UpdateGlobal and is also called directly by Reload, which is a
+// netmaster-internal operation (triggered by SIGHUP on the process itself)
+// rather than an API call made on a caller's behalf, so it isn't subject to
+// the authz chain.
+func updateGlobal(stateDriver core.StateDriver, gc *intent.ConfigGlobal) error {
 	log.Infof("Received global update with intent {%v}", gc)
 	var err error
 	gcfgUpdateList := []string{}
@@ -286,7 +347,11 @@ func UpdateGlobal(stateDriver core.StateDriver, gc *intent.ConfigGlobal) error {
 }
 
 // DeleteGlobal delete global state
-func DeleteGlobal(stateDriver core.StateDriver) error {
+func DeleteGlobal(ctx context.Context, stateDriver core.StateDriver) error {
+	if err := checkAuthz(ctx, "DeleteGlobal", ""); err != nil {
+		return err
+	}
+
 	masterGc := &mastercfg.GlobConfig{}
 	masterGc.StateDriver = stateDriver
 	err := masterGc.Read("")
@@ -332,125 +397,108 @@ func DeleteGlobal(stateDriver core.StateDriver) error {
 }
 
 // CreateTenant sets the tenant's state according to the passed ConfigTenant.
-func CreateTenant(stateDriver core.StateDriver, tenant *intent.ConfigTenant) error {
+func CreateTenant(ctx context.Context, stateDriver core.StateDriver, tenant *intent.ConfigTenant) error {
 	err := validateTenantConfig(tenant)
 	if err != nil {
 		return err
 	}
 
+	if err := checkAuthz(ctx, "CreateTenant", tenant.Name); err != nil {
+		return err
+	}
+
 	if IsDNSEnabled() {
-		// start skydns container
-		err = startServiceContainer(tenant.Name)
+		// register the tenant's zone with the embedded resolver
+		err = resolver.registerTenantZone(tenant.Name)
 		if err != nil {
-			log.Errorf("Error starting service container. Err: %v. Disabling DNS option.", err)
+			log.Errorf("Error registering DNS zone for tenant. Err: %v. Disabling DNS option.", err)
 			SetDNSEnabled(false)
+		} else {
+			startDNSSupervisor(tenant.Name)
 		}
 	}
 
 	return nil
 }
 
-func startServiceContainer(tenantName string) error {
-	var err error
-	docker, err := utils.GetDockerClient()
-	if err != nil {
-		log.Errorf("Unable to connect to docker. Error %v", err)
+// DeleteTenantID deletes a tenant from the state store, by ID.
+func DeleteTenantID(ctx context.Context, stateDriver core.StateDriver, tenantID string) error {
+	if err := checkAuthz(ctx, "DeleteTenantID", tenantID); err != nil {
 		return err
 	}
 
-	// pull the skydns image if it does not exist
-	imageName := defaultSkyDNSImage
-	_, err = docker.InspectImage(imageName)
-	if err != nil {
-		pullOperation := func() error {
-			err := docker.PullImage(imageName, nil)
-			if err != nil {
-				log.Errorf("Retrying to pull image: %s", imageName)
-				return err
-			}
-			return nil
-		}
+	if IsDNSEnabled() {
+		stopDNSSupervisor(tenantID)
 
-		err = backoff.Retry(pullOperation, backoff.NewExponentialBackOff())
+		err := resolver.unregisterTenantZone(tenantID)
 		if err != nil {
-			log.Errorf("Unable to pull image: %s", imageName)
+			log.Errorf("Error in unregistering DNS zone for tenant: %+v", tenantID)
 			return err
 		}
 	}
 
-	containerConfig := &dockerclient.ContainerConfig{
-		Image: imageName,
-		Env: []string{"ETCD_MACHINES=http://172.17.0.1:4001",
-			"SKYDNS_NAMESERVERS=8.8.8.8:53",
-			"SKYDNS_ADDR=0.0.0.0:53",
-			"SKYDNS_DOMAIN=" + tenantName}}
+	return nil
+}
 
-	containerID, err := docker.CreateContainer(containerConfig, getDNSName(tenantName), nil)
+// DeleteTenant deletes a tenant from the state store based on its ConfigTenant.
+func DeleteTenant(ctx context.Context, stateDriver core.StateDriver, tenant *intent.ConfigTenant) error {
+	err := validateTenantConfig(tenant)
 	if err != nil {
-		log.Errorf("Error creating DNS container for tenant: %s. Error: %s", tenantName, err)
 		return err
 	}
 
-	hostConfig := &dockerclient.HostConfig{
-		RestartPolicy: dockerclient.RestartPolicy{Name: "always"}}
+	if err := checkAuthz(ctx, "DeleteTenant", tenant.Name); err != nil {
+		return err
+	}
 
-	// Start the container
-	err = docker.StartContainer(containerID, hostConfig)
-	if err != nil {
-		log.Errorf("Error starting DNS container for tenant: %s. Error: %s", tenantName, err)
+	if len(tenant.Networks) == 0 {
+		return DeleteTenantID(ctx, stateDriver, tenant.Name)
 	}
 
-	return err
+	return nil
 }
 
-func stopAndRemoveServiceContainer(tenantName string) error {
-	var err error
-	docker, err := utils.GetDockerClient()
-	if err != nil {
-		log.Errorf("Unable to connect to docker. Error %v", err)
+// CreateEndpoint publishes ep's DNS record to its tenant's embedded
+// resolver zone, keyed by container/host name and, if ep belongs to a
+// service, by service name too.
+func CreateEndpoint(ctx context.Context, tenantName, networkName string, ep *intent.ConfigEP) error {
+	if err := checkAuthz(ctx, "CreateEndpoint", tenantName); err != nil {
 		return err
 	}
 
-	dnsContName := getDNSName(tenantName)
-	// Stop the container
-	err = docker.StopContainer(dnsContName, 10)
-	if err != nil {
-		log.Errorf("Error stopping DNS container for tenant: %s. Error: %s", tenantName, err)
-		return err
+	if !IsDNSEnabled() {
+		return nil
 	}
 
-	err = docker.RemoveContainer(dnsContName, true, true)
-	if err != nil {
-		log.Errorf("Error removing DNS container for tenant: %s. Error: %s", tenantName, err)
-		return err
-	}
-	return err
-}
-
-// DeleteTenantID deletes a tenant from the state store, by ID.
-func DeleteTenantID(stateDriver core.StateDriver, tenantID string) error {
-	if IsDNSEnabled() {
-		err := stopAndRemoveServiceContainer(tenantID)
-		if err != nil {
-			log.Errorf("Error in stopping service container for tenant: %+v", tenantID)
-			return err
-		}
-	}
+	resolver.publish(mastercfg.EndpointUpdate{
+		Tenant:      tenantName,
+		Name:        dns.Fqdn(getEpName(networkName, ep) + "." + tenantName),
+		ServiceName: ep.ServiceName,
+		Port:        ep.ServicePort,
+		IPv4Addr:    net.ParseIP(ep.IPAddress),
+		IPv6Addr:    net.ParseIP(ep.IPv6Address),
+	})
 
 	return nil
 }
 
-// DeleteTenant deletes a tenant from the state store based on its ConfigTenant.
-func DeleteTenant(stateDriver core.StateDriver, tenant *intent.ConfigTenant) error {
-	err := validateTenantConfig(tenant)
-	if err != nil {
+// DeleteEndpoint withdraws the DNS record CreateEndpoint published for ep.
+func DeleteEndpoint(ctx context.Context, tenantName, networkName string, ep *intent.ConfigEP) error {
+	if err := checkAuthz(ctx, "DeleteEndpoint", tenantName); err != nil {
 		return err
 	}
 
-	if len(tenant.Networks) == 0 {
-		return DeleteTenantID(stateDriver, tenant.Name)
+	if !IsDNSEnabled() {
+		return nil
 	}
 
+	resolver.publish(mastercfg.EndpointUpdate{
+		Tenant:      tenantName,
+		Name:        dns.Fqdn(getEpName(networkName, ep) + "." + tenantName),
+		ServiceName: ep.ServiceName,
+		Deleted:     true,
+	})
+
 	return nil
 }
 
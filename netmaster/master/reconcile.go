@@ -0,0 +1,200 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/gstate"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const defaultReconcileInterval = 5 * time.Minute
+
+// reconcileFunc is Reconcile, kept as a var so StartReconciler's tests can
+// replace it without touching OVS or a container runtime.
+var reconcileFunc = Reconcile
+
+// StartReconciler runs Reconcile once immediately and then again on every
+// tick of interval, until the returned channel is closed. Like WatchReload,
+// it starts its own goroutine; nothing in netmaster's startup calls it.
+func StartReconciler(stateDriver core.StateDriver, interval time.Duration) chan struct{} {
+	stopCh := make(chan struct{})
+
+	go func() {
+		if err := reconcileFunc(stateDriver); err != nil {
+			log.Errorf("Reconcile: startup pass failed. Error: %v", err)
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := reconcileFunc(stateDriver); err != nil {
+					log.Errorf("Reconcile: periodic pass failed. Error: %v", err)
+				}
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	return stopCh
+}
+
+// reconcileStats tallies what a Reconcile pass reclaimed, for the log line
+// it emits when it's done.
+type reconcileStats struct {
+	networksPruned  int
+	endpointsPruned int
+	vlansFreed      int
+	vxlansFreed     int
+}
+
+// Reconcile walks mastercfg's network and endpoint state, cross-references
+// it against the live OVS bridges and the runtime's container list, and
+// deletes orphaned entries whose referencing container/network no longer
+// exists. StartReconciler runs it once when called and periodically
+// afterwards to correct the drift a crash mid-operation leaves behind.
+func Reconcile(stateDriver core.StateDriver) error {
+	gstate.GlobalMutex.Lock()
+	defer gstate.GlobalMutex.Unlock()
+
+	bridges, err := dumpOvsBridges()
+	if err != nil {
+		log.Errorf("Reconcile: unable to read OVS bridges. Error: %v", err)
+		return err
+	}
+
+	runtimeContainers, err := listRuntimeContainers()
+	if err != nil {
+		log.Errorf("Reconcile: unable to list runtime containers. Error: %v", err)
+		return err
+	}
+
+	gCfg := &gstate.Cfg{}
+	gCfg.StateDriver = stateDriver
+	gCfg.Read("global")
+
+	stats := &reconcileStats{}
+
+	endpoints, err := mastercfg.ReadAllEndpoints(stateDriver)
+	if err != nil {
+		log.Errorf("Reconcile: unable to read endpoint state. Error: %v", err)
+		return err
+	}
+
+	for _, ep := range endpoints {
+		if runtimeContainers[ep.ContainerID] {
+			continue
+		}
+
+		log.Infof("Reconcile: pruning orphaned endpoint %s (container %s no longer exists)", ep.EndpointID, ep.ContainerID)
+
+		if err := ep.Clear(); err != nil {
+			log.Errorf("Reconcile: error clearing endpoint %s. Error: %v", ep.EndpointID, err)
+			continue
+		}
+
+		if ep.VLANTag != 0 {
+			gCfg.FreeVLAN(ep.VLANTag)
+			stats.vlansFreed++
+		}
+		if ep.VXLANTag != 0 {
+			gCfg.FreeVXLAN(ep.VXLANTag)
+			stats.vxlansFreed++
+		}
+
+		stats.endpointsPruned++
+	}
+
+	networks, err := mastercfg.ReadAllNetworks(stateDriver)
+	if err != nil {
+		log.Errorf("Reconcile: unable to read network state. Error: %v", err)
+		return err
+	}
+
+	for _, nw := range networks {
+		if bridges[nw.NetworkName] {
+			continue
+		}
+
+		log.Infof("Reconcile: pruning orphaned network %s (no matching OVS bridge)", nw.NetworkName)
+
+		if err := nw.Clear(); err != nil {
+			log.Errorf("Reconcile: error clearing network %s. Error: %v", nw.NetworkName, err)
+			continue
+		}
+
+		stats.networksPruned++
+	}
+
+	if err := gCfg.Write(); err != nil {
+		log.Errorf("Reconcile: error persisting freed resources. Error: %v", err)
+		return err
+	}
+
+	log.Infof("Reconcile: pruned %d endpoints, %d networks, freed %d vlans and %d vxlans",
+		stats.endpointsPruned, stats.networksPruned, stats.vlansFreed, stats.vxlansFreed)
+
+	return nil
+}
+
+// dumpOvsBridges returns the set of bridge names currently known to OVS.
+func dumpOvsBridges() (map[string]bool, error) {
+	out, err := exec.Command("ovs-vsctl", "list-br").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	bridges := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line != "" {
+			bridges[line] = true
+		}
+	}
+
+	return bridges, nil
+}
+
+// listRuntimeContainers returns the set of container IDs the runtime (e.g.
+// Docker) currently knows about.
+func listRuntimeContainers() (map[string]bool, error) {
+	docker, err := utils.GetDockerClient()
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := docker.ListContainers(true, false, "")
+	if err != nil {
+		return nil, err
+	}
+
+	ids := map[string]bool{}
+	for _, c := range containers {
+		ids[c.Id] = true
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,44 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+)
+
+func TestStartReconcilerRunsAtStartupAndPeriodically(t *testing.T) {
+	orig := reconcileFunc
+	defer func() { reconcileFunc = orig }()
+
+	var calls int32
+	reconcileFunc = func(stateDriver core.StateDriver) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}
+
+	stopCh := StartReconciler(nil, 10*time.Millisecond)
+	defer close(stopCh)
+
+	time.Sleep(35 * time.Millisecond)
+
+	if n := atomic.LoadInt32(&calls); n < 2 {
+		t.Errorf("expected at least 2 reconcile passes (startup + periodic), got %d", n)
+	}
+}
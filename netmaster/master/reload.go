@@ -0,0 +1,191 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/gstate"
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// NetmasterConfig is the subset of runtime configuration that can be
+// changed without restarting netmaster: the forwarding mode, ARP mode and
+// VLAN/VXLAN fields UpdateGlobal accepts (not NwInfraType) plus the two
+// masterRTCfg knobs.
+type NetmasterConfig struct {
+	ClusterMode string
+	DNSEnabled  bool
+	FwdMode     string
+	ArpMode     string
+	VLANs       string
+	VXLANs      string
+}
+
+// ConfigLoader returns the desired NetmasterConfig for a reload, e.g. by
+// re-reading the on-disk config file netmaster was started with.
+type ConfigLoader func() (*NetmasterConfig, error)
+
+// WatchReload registers a SIGHUP handler that calls load and applies the
+// result via Reload.
+func WatchReload(stateDriver core.StateDriver, load ConfigLoader) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+
+	go func() {
+		for range sigCh {
+			cfg, err := load()
+			if err != nil {
+				log.Errorf("Error loading config for reload. Error: %v", err)
+				continue
+			}
+
+			if err := Reload(stateDriver, cfg); err != nil {
+				log.Errorf("Error applying reload. Error: %v", err)
+			}
+		}
+	}()
+}
+
+// Reload diffs cfg against the running configuration, validates the DNS
+// and VLAN/VXLAN transitions, and applies only the fields that changed. A
+// failed validation or cluster mode change aborts the reload before the
+// global config is touched. If the global update fails, the cluster mode
+// change is reverted; whatever updateGlobal had already written before it
+// failed is not reverted.
+func Reload(stateDriver core.StateDriver, cfg *NetmasterConfig) error {
+	if cfg == nil {
+		return core.Errorf("nil reload config")
+	}
+
+	gCfg := &gstate.Cfg{}
+	gCfg.StateDriver = stateDriver
+	gCfg.Read("global")
+
+	current := currentConfig(gCfg)
+
+	if cfg.DNSEnabled != current.DNSEnabled {
+		if err := validateDNSTransition(current.DNSEnabled, cfg.DNSEnabled); err != nil {
+			return err
+		}
+	}
+
+	if cfg.VLANs != "" && cfg.VLANs != current.VLANs {
+		_, vlansInUse := gCfg.GetVlansInUse()
+		if !gCfg.CheckInBitRange(cfg.VLANs, vlansInUse, "vlan") {
+			return fmt.Errorf("cannot shrink vlan range due to existing vlans %s", vlansInUse)
+		}
+	}
+
+	if cfg.VXLANs != "" && cfg.VXLANs != current.VXLANs {
+		_, vxlansInUse := gCfg.GetVxlansInUse()
+		if !gCfg.CheckInBitRange(cfg.VXLANs, vxlansInUse, "vxlan") {
+			return fmt.Errorf("cannot shrink vxlan range due to existing vxlans %s", vxlansInUse)
+		}
+	}
+
+	if cfg.ClusterMode != "" && cfg.ClusterMode != current.ClusterMode {
+		if err := SetClusterMode(cfg.ClusterMode); err != nil {
+			return err
+		}
+		log.Infof("Reload: cluster mode changed from %s to %s", current.ClusterMode, cfg.ClusterMode)
+	}
+
+	gc := &intent.ConfigGlobal{}
+	if cfg.FwdMode != current.FwdMode {
+		gc.FwdMode = cfg.FwdMode
+	}
+	if cfg.ArpMode != current.ArpMode {
+		gc.ArpMode = cfg.ArpMode
+	}
+	if cfg.VLANs != current.VLANs {
+		gc.VLANs = cfg.VLANs
+	}
+	if cfg.VXLANs != current.VXLANs {
+		gc.VXLANs = cfg.VXLANs
+	}
+
+	// Reload is netmaster's own SIGHUP handler, not a call made on behalf of
+	// an external caller, so it applies the update directly rather than
+	// through UpdateGlobal's authz check (which would otherwise deny it as
+	// soon as any authz plugin is installed, since a reload carries no
+	// caller identity).
+	if err := updateGlobal(stateDriver, gc); err != nil {
+		// roll back the cluster mode change so the process isn't left
+		// with a partially applied config.
+		SetClusterMode(current.ClusterMode)
+		return err
+	}
+
+	if cfg.DNSEnabled != current.DNSEnabled {
+		if err := SetDNSEnabled(cfg.DNSEnabled); err != nil {
+			return err
+		}
+		log.Infof("Reload: dns enabled changed from %v to %v", current.DNSEnabled, cfg.DNSEnabled)
+	}
+
+	logConfigDelta(current, cfg)
+
+	return nil
+}
+
+func validateDNSTransition(from, to bool) error {
+	resolver.mu.RLock()
+	zoneCount := len(resolver.zones)
+	resolver.mu.RUnlock()
+
+	if from && !to && zoneCount > 0 {
+		return core.Errorf("cannot disable dns while %d tenant zones are still populated, drain them first", zoneCount)
+	}
+	return nil
+}
+
+func currentConfig(gCfg *gstate.Cfg) *NetmasterConfig {
+	masterGc := &mastercfg.GlobConfig{}
+	masterGc.StateDriver = gCfg.StateDriver
+	masterGc.Read("global")
+
+	return &NetmasterConfig{
+		ClusterMode: GetClusterMode(),
+		DNSEnabled:  IsDNSEnabled(),
+		FwdMode:     masterGc.FwdMode,
+		ArpMode:     masterGc.ArpMode,
+		VLANs:       gCfg.Auto.VLANs,
+		VXLANs:      gCfg.Auto.VXLANs,
+	}
+}
+
+func logConfigDelta(current, next *NetmasterConfig) {
+	if current.FwdMode != next.FwdMode && next.FwdMode != "" {
+		log.Infof("Reload: forwarding mode changed from %s to %s", current.FwdMode, next.FwdMode)
+	}
+	if current.ArpMode != next.ArpMode && next.ArpMode != "" {
+		log.Infof("Reload: arp mode changed from %s to %s", current.ArpMode, next.ArpMode)
+	}
+	if current.VLANs != next.VLANs && next.VLANs != "" {
+		log.Infof("Reload: vlan range changed from %s to %s", current.VLANs, next.VLANs)
+	}
+	if current.VXLANs != next.VXLANs && next.VXLANs != "" {
+		log.Infof("Reload: vxlan range changed from %s to %s", current.VXLANs, next.VXLANs)
+	}
+}
@@ -0,0 +1,45 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import "testing"
+
+func TestValidateDNSTransition(t *testing.T) {
+	if err := validateDNSTransition(false, true); err != nil {
+		t.Errorf("enabling dns with no zones should be allowed, got error: %v", err)
+	}
+
+	if err := validateDNSTransition(true, false); err != nil {
+		t.Errorf("disabling dns with no zones should be allowed, got error: %v", err)
+	}
+
+	resolver.mu.Lock()
+	resolver.zones["tenant1"] = newDNSZone("tenant1")
+	resolver.mu.Unlock()
+	defer func() {
+		resolver.mu.Lock()
+		delete(resolver.zones, "tenant1")
+		resolver.mu.Unlock()
+	}()
+
+	if err := validateDNSTransition(true, false); err == nil {
+		t.Error("expected disabling dns with populated zones to be rejected")
+	}
+
+	if err := validateDNSTransition(false, true); err != nil {
+		t.Errorf("enabling dns should never be rejected by zone population, got error: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+// +build swarm
+
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const swarmReconcileInterval = 10 * time.Second
+
+// swarmReconciler discovers Swarm services/tasks and keeps the embedded DNS
+// resolver's zones in sync with them instead of raw container events.
+type swarmReconciler struct {
+	cli    *client.Client
+	stopCh chan struct{}
+
+	// lastTasks remembers, per service DNS name, the task DNS names applyService
+	// registered on the previous pass, so tasks that have since stopped or been
+	// rescheduled away have their stale A/SRV records pruned instead of
+	// accumulating forever.
+	lastTasks map[string]map[string]bool
+}
+
+var (
+	swarmMu               sync.Mutex
+	activeSwarmReconciler *swarmReconciler
+)
+
+// startSwarmReconciler connects to the Swarm API and starts polling it for
+// service/task changes. It's the swarm-mode replacement for the raw
+// container create/delete events the other cluster modes use.
+func startSwarmReconciler() error {
+	swarmMu.Lock()
+	defer swarmMu.Unlock()
+
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		log.Errorf("Unable to connect to the swarm API. Error: %v", err)
+		return err
+	}
+
+	r := &swarmReconciler{cli: cli, stopCh: make(chan struct{}), lastTasks: map[string]map[string]bool{}}
+	activeSwarmReconciler = r
+
+	go r.run()
+
+	return nil
+}
+
+// stopSwarmReconciler stops polling the Swarm API, e.g. when Reload moves
+// the cluster mode away from "swarm".
+func stopSwarmReconciler() {
+	swarmMu.Lock()
+	defer swarmMu.Unlock()
+
+	if activeSwarmReconciler == nil {
+		return
+	}
+
+	close(activeSwarmReconciler.stopCh)
+	activeSwarmReconciler = nil
+}
+
+func (r *swarmReconciler) run() {
+	ticker := time.NewTicker(swarmReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.reconcile()
+		case <-r.stopCh:
+			return
+		}
+	}
+}
+
+// reconcile maps running Swarm services/tasks into the tenant DNS zones,
+// scoped to the tenant named in each service's "contiv.tenant" label.
+func (r *swarmReconciler) reconcile() {
+	ctx := context.Background()
+
+	services, err := r.cli.ServiceList(ctx, types.ServiceListOptions{})
+	if err != nil {
+		log.Errorf("Unable to list swarm services. Error: %v", err)
+		return
+	}
+
+	for _, svc := range services {
+		tenantName := svc.Spec.Labels["contiv.tenant"]
+		if tenantName == "" {
+			continue
+		}
+
+		taskFilter := filters.NewArgs()
+		taskFilter.Add("service", svc.ID)
+
+		tasks, err := r.cli.TaskList(ctx, types.TaskListOptions{Filters: taskFilter})
+		if err != nil {
+			log.Errorf("Unable to list tasks for swarm service %s. Error: %v", svc.Spec.Name, err)
+			continue
+		}
+
+		r.applyService(tenantName, svc, tasks)
+	}
+}
+
+// applyService registers one A/AAAA record per running task under its own
+// task-scoped name, plus one SRV record per task under the service's DNS
+// name, so a client can resolve the SRV set to discover every live task and
+// its port. Tasks that disappear between passes (stopped, rescheduled) have
+// their records pruned via r.lastTasks.
+func (r *swarmReconciler) applyService(tenantName string, svc swarm.Service, tasks []swarm.Task) {
+	resolver.mu.RLock()
+	zone := resolver.zones[tenantName]
+	resolver.mu.RUnlock()
+	if zone == nil {
+		return
+	}
+
+	svcName := svc.Spec.Name + "." + tenantName + "."
+	port := servicePort(svc)
+
+	seen := map[string]bool{}
+
+	for _, task := range tasks {
+		if task.Status.State != swarm.TaskStateRunning || task.ID == "" {
+			continue
+		}
+
+		taskName := task.ID + "." + svcName
+		seen[taskName] = true
+
+		// rebuild the task's addresses so repeated passes don't stack
+		// duplicates onto the same record
+		zone.delRecord(taskName)
+
+		for _, na := range task.NetworksAttachments {
+			for _, addr := range na.Addresses {
+				ip, _, err := net.ParseCIDR(addr)
+				if err != nil {
+					continue
+				}
+				if ip.To4() != nil {
+					zone.addRecord(taskName, ip, nil)
+				} else {
+					zone.addRecord(taskName, nil, ip)
+				}
+			}
+		}
+	}
+
+	for taskName := range r.lastTasks[svcName] {
+		if !seen[taskName] {
+			zone.delRecord(taskName)
+		}
+	}
+	r.lastTasks[svcName] = seen
+
+	zone.clearSRV(svcName)
+	for taskName := range seen {
+		zone.addSRV(svcName, taskName, port)
+	}
+}
+
+// servicePort returns the target (container-side) port of the first port
+// configured on svc's endpoint, or 0 if it has none.
+func servicePort(svc swarm.Service) uint16 {
+	for _, p := range svc.Endpoint.Ports {
+		return uint16(p.TargetPort)
+	}
+	return 0
+}
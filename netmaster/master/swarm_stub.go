@@ -0,0 +1,29 @@
+// +build !swarm
+
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import "github.com/contiv/netplugin/core"
+
+// startSwarmReconciler/stopSwarmReconciler are stubbed out unless netplugin
+// is built with the "swarm" tag, so kubernetes-only deployments don't pull
+// in the Swarm API client dependency.
+func startSwarmReconciler() error {
+	return core.Errorf("swarm cluster mode requires netmaster built with the swarm build tag")
+}
+
+func stopSwarmReconciler() {}
@@ -0,0 +1,80 @@
+// +build swarm
+
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func runningTask(id, ip string) swarm.Task {
+	return swarm.Task{
+		ID:     id,
+		Status: swarm.TaskStatus{State: swarm.TaskStateRunning},
+		NetworksAttachments: []swarm.NetworkAttachment{
+			{Addresses: []string{ip + "/24"}},
+		},
+	}
+}
+
+func TestApplyServiceRegistersAndPrunesSRV(t *testing.T) {
+	const tenant = "swarmtenant"
+
+	if err := resolver.registerTenantZone(tenant); err != nil {
+		t.Fatalf("unable to register test tenant zone: %v", err)
+	}
+	defer resolver.unregisterTenantZone(tenant)
+
+	resolver.mu.RLock()
+	zone := resolver.zones[tenant]
+	resolver.mu.RUnlock()
+
+	svc := swarm.Service{}
+	svc.Spec.Name = "web"
+	svc.Endpoint.Ports = []swarm.PortConfig{{TargetPort: 8080}}
+
+	r := &swarmReconciler{lastTasks: map[string]map[string]bool{}}
+
+	r.applyService(tenant, svc, []swarm.Task{
+		runningTask("task1", "10.1.1.10"),
+		runningTask("task2", "10.1.1.11"),
+	})
+
+	svcName := "web." + tenant + "."
+	srvs := zone.srv[svcName]
+	if len(srvs) != 2 {
+		t.Fatalf("expected 2 SRV records after the first pass, got %d", len(srvs))
+	}
+	if zone.lookup("task1."+svcName) == nil {
+		t.Errorf("expected an A record for task1's task name")
+	}
+
+	// task2 goes away on the next pass; its records should be pruned.
+	r.applyService(tenant, svc, []swarm.Task{
+		runningTask("task1", "10.1.1.10"),
+	})
+
+	srvs = zone.srv[svcName]
+	if len(srvs) != 1 || srvs[0].Target != "task1."+svcName {
+		t.Fatalf("expected only task1's SRV record to remain, got %v", srvs)
+	}
+	if zone.lookup("task2."+svcName) != nil {
+		t.Errorf("expected task2's A record to be pruned once it stopped running")
+	}
+}